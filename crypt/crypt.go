@@ -0,0 +1,123 @@
+// Package crypt encrypts checkpoint archives in transit and verifies
+// their integrity, since a checkpoint image contains full process memory
+// (secrets, keys, tokens) and shouldn't travel as a bare tarball.
+package crypt
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/marcosnils/cmt/cmd"
+)
+
+// Config describes how to AES-256-CBC encrypt/decrypt checkpoint archives,
+// via openssl, using either a key file or a passphrase. openssl's enc
+// command has no AEAD cipher support, so integrity isn't carried by the
+// cipher itself -- it's covered separately by the SHA-256 digest every
+// transfer is already verified against (see Digest/VerifyDigest).
+type Config struct {
+	KeyFile    string
+	Passphrase string
+}
+
+// Enabled reports whether encryption was requested on the command line.
+func (cfg *Config) Enabled() bool {
+	return cfg != nil && (cfg.KeyFile != "" || cfg.Passphrase != "")
+}
+
+// passArgs returns the openssl "-pass" argument to use on host c, along
+// with a cleanup func that must be called once the openssl invocation
+// finishes. A KeyFile is passed straight through. A Passphrase is never
+// passed as "-pass pass:...", since that would put it in the argv of the
+// openssl process for its entire (potentially long) run, visible to any
+// local user on c via ps/proc; instead it's written to a private temp
+// file that only exists for the cleanup's window.
+func (cfg *Config) passArgs(c cmd.Cmd) (args []string, cleanup func(), err error) {
+	if cfg.KeyFile != "" {
+		return []string{"-pass", "file:" + cfg.KeyFile}, func() {}, nil
+	}
+
+	out, _, err := c.Run("mktemp")
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpFile := strings.TrimSpace(out)
+
+	write := fmt.Sprintf("umask 077 && printf %%s %s > %s", shellQuote(cfg.Passphrase), tmpFile)
+	if _, _, err := c.Run("sh", "-c", write); err != nil {
+		return nil, nil, err
+	}
+
+	cleanup = func() {
+		if _, _, err := c.Run("rm", "-f", tmpFile); err != nil {
+			log.Printf("Error removing temporary passphrase file %s: %v", tmpFile, err)
+		}
+	}
+	return []string{"-pass", "file:" + tmpFile}, cleanup, nil
+}
+
+// shellQuote single-quotes s for safe use as a literal sh argument.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Encrypt runs openssl on host c to AES-256-CBC encrypt plainFile into
+// plainFile+".enc".
+func (cfg *Config) Encrypt(c cmd.Cmd, plainFile string) (string, error) {
+	passArgs, cleanup, err := cfg.passArgs(c)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	encFile := plainFile + ".enc"
+	args := append([]string{"enc", "-aes-256-cbc", "-salt", "-in", plainFile, "-out", encFile}, passArgs...)
+	if _, _, err := c.Run("openssl", args...); err != nil {
+		return "", err
+	}
+	return encFile, nil
+}
+
+// Decrypt runs openssl on host c to decrypt encFile (as produced by
+// Encrypt) back into its original, unsuffixed path.
+func (cfg *Config) Decrypt(c cmd.Cmd, encFile string) (string, error) {
+	passArgs, cleanup, err := cfg.passArgs(c)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	plainFile := strings.TrimSuffix(encFile, ".enc")
+	args := append([]string{"enc", "-d", "-aes-256-cbc", "-in", encFile, "-out", plainFile}, passArgs...)
+	if _, _, err := c.Run("openssl", args...); err != nil {
+		return "", err
+	}
+	return plainFile, nil
+}
+
+// Digest computes the SHA-256 digest of path on host c.
+func Digest(c cmd.Cmd, path string) (string, error) {
+	out, _, err := c.Run("sha256sum", path)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("unexpected sha256sum output: %q", out)
+	}
+	return fields[0], nil
+}
+
+// VerifyDigest recomputes the SHA-256 digest of path on host c and
+// returns an error if it doesn't match want.
+func VerifyDigest(c cmd.Cmd, path, want string) error {
+	got, err := Digest(c, path)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("digest mismatch for %s: got %s, want %s", path, got, want)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package crypt
+
+import "testing"
+
+func TestShellQuote(t *testing.T) {
+	cases := map[string]string{
+		"hunter2":              "'hunter2'",
+		"":                     "''",
+		"it's":                 `'it'\''s'`,
+		"$(rm -rf /)":          "'$(rm -rf /)'",
+		"pass; rm -rf / #evil": "'pass; rm -rf / #evil'",
+	}
+	for in, want := range cases {
+		if got := shellQuote(in); got != want {
+			t.Errorf("shellQuote(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestConfigEnabled(t *testing.T) {
+	cases := []struct {
+		name string
+		cfg  *Config
+		want bool
+	}{
+		{"nil", nil, false},
+		{"empty", &Config{}, false},
+		{"key file", &Config{KeyFile: "/path/to/key"}, true},
+		{"passphrase", &Config{Passphrase: "hunter2"}, true},
+	}
+	for _, c := range cases {
+		if got := c.cfg.Enabled(); got != c.want {
+			t.Errorf("%s: Enabled() = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
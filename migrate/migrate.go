@@ -4,11 +4,17 @@ import (
 	"fmt"
 	"log"
 	"path/filepath"
-	"sync"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/codegangsta/cli"
 	"github.com/marcosnils/cmt/cmd"
+	"github.com/marcosnils/cmt/crypt"
+	"github.com/marcosnils/cmt/progress"
+	"github.com/marcosnils/cmt/rollback"
+	"github.com/marcosnils/cmt/spec"
+	"github.com/marcosnils/cmt/transport"
 	"github.com/marcosnils/cmt/validate"
 )
 
@@ -24,156 +30,467 @@ var Command = cli.Command{
 			Name:  "dst",
 			Usage: "Target host to migrate the container",
 		},
-		cli.BoolFlag{
+		cli.IntFlag{
 			Name:  "pre-dump",
-			Usage: "Perform a pre-dump to minimize downtime",
+			Usage: "Perform N successive pre-dumps before the final checkpoint to minimize downtime (e.g. --pre-dump=3)",
+		},
+		cli.StringFlag{
+			Name:  "transport",
+			Usage: "Backend used to move checkpoint images between src and dst, e.g. s3://bucket/prefix, webdav://host/path, sftp://host/path, localdir:///shared/path (default: scp, requiring src and dst to reach each other directly)",
+		},
+		cli.StringFlag{
+			Name:  "encrypt-key",
+			Usage: "Path (readable on both src and dst) to a key file used to AES-256-CBC encrypt checkpoint archives in transit",
+		},
+		cli.StringFlag{
+			Name:  "encrypt-passphrase",
+			Usage: "Passphrase used to AES-256-CBC encrypt checkpoint archives in transit (alternative to --encrypt-key)",
+		},
+		cli.StringFlag{
+			Name:  "restore-timeout",
+			Usage: "Max time to wait for the restored container to start before giving up and rolling back, e.g. 30s (default: no timeout)",
+		},
+		cli.BoolFlag{
+			Name:  "no-progress",
+			Usage: "Don't print progress for individual phases (tar, transfer, extract, restore), but keep top-level status lines",
+		},
+		cli.BoolFlag{
+			Name:  "silent",
+			Usage: "Suppress all non-error output, including top-level status lines",
+		},
+		cli.StringSliceFlag{
+			Name:  "include-mounts",
+			Usage: "Absolute bind mount source paths on src to capture and restore along with the container spec",
+		},
+		cli.StringSliceFlag{
+			Name:  "rewrite",
+			Usage: "Rewrite a host-specific path in the captured spec on dst, e.g. --rewrite /data/src=/data/dst (repeatable)",
 		},
 	},
 	Action: func(c *cli.Context) {
+		reporter := progress.New(c.Bool("no-progress"), c.Bool("silent"))
+
 		srcUrl := validate.ParseURL(c.String("src"))
 		dstUrl := validate.ParseURL(c.String("dst"))
 
-		log.Println("Performing validations")
+		reporter.Log("Performing validations")
 		src, dst := validate.Validate(srcUrl, dstUrl)
 
-		log.Println("Preparing everything to do a checkpoint")
+		transportRaw := c.String("transport")
+		push, err := transport.New(transport.Scheme(transportRaw), src)
+		if err != nil {
+			log.Fatal("Error configuring transport:", err)
+		}
+		pull, err := transport.New(transport.Scheme(transportRaw), dst)
+		if err != nil {
+			log.Fatal("Error configuring transport:", err)
+		}
+
+		encryption := &crypt.Config{
+			KeyFile:    c.String("encrypt-key"),
+			Passphrase: c.String("encrypt-passphrase"),
+		}
+
+		plan := &rollback.Plan{}
+
+		// fatal rolls back whatever plan has recorded so far, then exits.
+		// Calling it before anything destructive has happened (plan still
+		// empty) is harmless, so every error from here on is routed
+		// through it rather than a bare log.Fatal, which would otherwise
+		// skip recovery and leave a checkpointed source container stopped
+		// forever.
+		fatal := func(msg string, err error) {
+			plan.Rollback()
+			log.Fatal(msg, err)
+		}
+
+		reporter.Log("Capturing container spec")
+		manifestFile := fmt.Sprintf("%s/container.json", srcUrl.Path)
+		if err := spec.Capture(src, srcUrl.Path, manifestFile, c.StringSlice("include-mounts")); err != nil {
+			fatal("Error capturing container spec:", err)
+		}
+
+		dstManifestFile := fmt.Sprintf("%s/container.json", dstUrl.Path)
+		manifestRef := transferRef(transportRaw, dst, "container.json", dstManifestFile)
+		dstManifestFile, err = transferImage(push, pull, encryption, reporter, src, dst, manifestFile, manifestRef, dstManifestFile)
+		if err != nil {
+			fatal("Error transferring container spec:", err)
+		}
+
+		if err := spec.Materialize(dst, dstManifestFile, dstUrl.Path, c.StringSlice("include-mounts"), c.StringSlice("rewrite")); err != nil {
+			fatal("Error materializing container spec on dst:", err)
+		}
+
+		reporter.Log("Preparing everything to do a checkpoint")
 		containerId := getContainerId(srcUrl.Path)
 		var imagesPath string
 		var restoreCmd cmd.Cmd
 		var migrateStart time.Time
 		var downtime time.Duration
 
-		if c.Bool("pre-dump") {
-			// Process pre-dump
-			predumpPath := fmt.Sprintf("%s/images/0", srcUrl.Path)
-			prepareDir(src, predumpPath)
-
-			checkpoint(src, containerId, predumpPath, true)
-
-			srcTarFile := fmt.Sprintf("%s/predump.tar.gz", srcUrl.Path)
-			prepareTar(src, srcTarFile, predumpPath)
-
-			prepareDir(dst, fmt.Sprintf("%s/images/0", dstUrl.Path))
+		rounds := c.Int("pre-dump")
 
-			log.Println("Copying predump image to dst")
-			err := cmd.Scp(src.URL(srcTarFile), dst.URL(fmt.Sprintf("%s/images/0", dstUrl.Path)))
+		if rounds > 0 {
+			prevImagesDir, prevDstImagesDir, err := preDumpRounds(src, dst, push, pull, encryption, reporter, plan, transportRaw, containerId, srcUrl.Path, dstUrl.Path, rounds)
 			if err != nil {
-				log.Fatal("Error copying predump image files to dst", err)
+				fatal("Error performing pre-dump round:", err)
 			}
 
-			dstTarFile := fmt.Sprintf("%s/images/0/predump.tar.gz", dstUrl.Path)
-			unpackTar(dst, dstTarFile, fmt.Sprintf("%s/images/0", dstUrl.Path))
-
 			// Process final image
 			migrateStart = time.Now()
 
-			imagesPath = fmt.Sprintf("%s/images/1", srcUrl.Path)
-			log.Println("Performing the checkpoint")
-			_, _, err = src.Run("sudo", "runc", "--id", containerId, "checkpoint", "--image-path", imagesPath, "--prev-images-dir", predumpPath)
+			imagesPath = fmt.Sprintf("%s/images/%d", srcUrl.Path, rounds)
+			reporter.Log("Performing the checkpoint")
+			_, _, err = src.Run("sudo", "runc", "--id", containerId, "checkpoint", "--image-path", imagesPath, "--prev-images-dir", prevImagesDir)
 			if err != nil {
-				log.Fatal("Error performing checkpoint:", err)
+				fatal("Error performing checkpoint:", err)
 			}
+			addCheckpointRollback(plan, src, containerId, imagesPath, srcUrl.Path)
 
-			srcTarFile = fmt.Sprintf("%s/dump.tar.gz", srcUrl.Path)
-			prepareTar(src, srcTarFile, imagesPath)
-			prepareDir(dst, fmt.Sprintf("%s/images/1", dstUrl.Path))
+			srcTarFile := fmt.Sprintf("%s/dump.tar.gz", srcUrl.Path)
+			tarSp := reporter.Start("Compressing final image on src")
+			if err := prepareTar(src, tarSp, srcTarFile, imagesPath); err != nil {
+				fatal("Error compressing image on src:", err)
+			}
+			finishWithSize(tarSp, src, srcTarFile)
 
-			log.Println("Copying predump image to dst")
-			err = cmd.Scp(src.URL(srcTarFile), dst.URL(fmt.Sprintf("%s/images/1", dstUrl.Path)))
+			dstImagesPath := fmt.Sprintf("%s/images/%d", dstUrl.Path, rounds)
+			if err := prepareDir(dst, dstImagesPath); err != nil {
+				fatal("Error preparing images dir on dst:", err)
+			}
+
+			dstTarFile := fmt.Sprintf("%s/dump.tar.gz", dstImagesPath)
+			ref := transferRef(transportRaw, dst, fmt.Sprintf("images/%d/dump.tar.gz", rounds), dstTarFile)
+			dstTarFile, err = transferImage(push, pull, encryption, reporter, src, dst, srcTarFile, ref, dstTarFile)
 			if err != nil {
-				log.Fatal("Error copying predump image files to dst", err)
+				fatal("Error transferring image to dst:", err)
 			}
+			addStagedImagesRollback(plan, dst, dstImagesPath)
 
-			dstTarFile = fmt.Sprintf("%s/images/1/dump.tar.gz", dstUrl.Path)
-			unpackTar(dst, dstTarFile, fmt.Sprintf("%s/images/1", dstUrl.Path))
+			extractSp := reporter.Start("Extracting final image on dst")
+			if err := unpackTar(dst, extractSp, dstTarFile, dstImagesPath); err != nil {
+				fatal("Error uncompressing image on dst:", err)
+			}
+			finishWithDirSize(extractSp, dst, dstImagesPath)
 
-			log.Println("Performing the restore")
+			if err := fixParentLink(dst, dstImagesPath, prevDstImagesDir); err != nil {
+				fatal("Error rewriting parent image link on dst:", err)
+			}
+
+			reporter.Log("Performing the restore")
 			configFilePath := fmt.Sprintf("%s/config.json", dstUrl.Path)
 			runtimeFilePath := fmt.Sprintf("%s/runtime.json", dstUrl.Path)
-			dstImagesPath := fmt.Sprintf("%s/images/1", dstUrl.Path)
 			restoreCmd, err = dst.Start("sudo", "runc", "--id", containerId, "restore", "--image-path", dstImagesPath, "--config-file", configFilePath, "--runtime-file", runtimeFilePath)
 			if err != nil {
-				log.Fatal("Error performing restore:", err)
+				fatal("Error performing restore:", err)
 			}
+			addRestoreRollback(plan, dst, containerId)
 
 		} else {
 			imagesPath = fmt.Sprintf("%s/images", srcUrl.Path)
-			prepareDir(src, imagesPath)
+			if err := prepareDir(src, imagesPath); err != nil {
+				fatal("Error preparing images dir on src:", err)
+			}
 
 			migrateStart = time.Now()
-			checkpoint(src, containerId, imagesPath, false)
+			if err := checkpoint(src, reporter, containerId, imagesPath, false, ""); err != nil {
+				fatal("Error performing checkpoint:", err)
+			}
+			addCheckpointRollback(plan, src, containerId, imagesPath, srcUrl.Path)
 
 			srcTarFile := fmt.Sprintf("%s/dump.tar.gz", srcUrl.Path)
-			prepareTar(src, srcTarFile, imagesPath)
+			tarSp := reporter.Start("Compressing checkpoint image on src")
+			if err := prepareTar(src, tarSp, srcTarFile, imagesPath); err != nil {
+				fatal("Error compressing image on src:", err)
+			}
+			finishWithSize(tarSp, src, srcTarFile)
 
-			prepareDir(dst, fmt.Sprintf("%s/images", dstUrl.Path))
+			dstImagesPath := fmt.Sprintf("%s/images", dstUrl.Path)
+			if err := prepareDir(dst, dstImagesPath); err != nil {
+				fatal("Error preparing images dir on dst:", err)
+			}
 
-			log.Println("Copying checkpoint image to dst")
-			err := cmd.Scp(src.URL(srcTarFile), dst.URL(fmt.Sprintf("%s/images", dstUrl.Path)))
+			dstTarFile := fmt.Sprintf("%s/images/dump.tar.gz", dstUrl.Path)
+			ref := transferRef(transportRaw, dst, "images/dump.tar.gz", dstTarFile)
+			dstTarFile, err = transferImage(push, pull, encryption, reporter, src, dst, srcTarFile, ref, dstTarFile)
 			if err != nil {
-				log.Fatal("Error copying image files to dst", err)
+				fatal("Error transferring image to dst:", err)
 			}
+			addStagedImagesRollback(plan, dst, dstImagesPath)
 
-			dstTarFile := fmt.Sprintf("%s/images/dump.tar.gz", dstUrl.Path)
-			unpackTar(dst, dstTarFile, fmt.Sprintf("%s/images", dstUrl.Path))
+			extractSp := reporter.Start("Extracting checkpoint image on dst")
+			if err := unpackTar(dst, extractSp, dstTarFile, dstImagesPath); err != nil {
+				fatal("Error uncompressing image on dst:", err)
+			}
+			finishWithDirSize(extractSp, dst, dstImagesPath)
 
-			log.Println("Performing the restore")
+			reporter.Log("Performing the restore")
 			configFilePath := fmt.Sprintf("%s/config.json", dstUrl.Path)
 			runtimeFilePath := fmt.Sprintf("%s/runtime.json", dstUrl.Path)
-			dstImagesPath := fmt.Sprintf("%s/images", dstUrl.Path)
 			restoreCmd, err = dst.Start("sudo", "runc", "--id", containerId, "restore", "--image-path", dstImagesPath, "--config-file", configFilePath, "--runtime-file", runtimeFilePath)
 			if err != nil {
-				log.Fatal("Error performing restore:", err)
+				fatal("Error performing restore:", err)
 			}
+			addRestoreRollback(plan, dst, containerId)
 
 		}
 
-		var restoreSucceed bool
-		var restoreError error
-		var wg sync.WaitGroup
-		wg.Add(1)
+		var restoreTimeoutCh <-chan time.Time
+		if raw := c.String("restore-timeout"); raw != "" {
+			d, err := time.ParseDuration(raw)
+			if err != nil {
+				fatal("Error parsing --restore-timeout:", err)
+			}
+			restoreTimeoutCh = time.After(d)
+		}
 
+		restoreDone := make(chan error, 1)
 		go func() {
-			restoreError = restoreCmd.Wait()
-			wg.Done()
+			restoreDone <- restoreCmd.Wait()
 		}()
 
+		restoreSp := reporter.Start("Waiting for container to start")
+		runningDone := make(chan struct{}, 1)
 		go func() {
-			log.Println("Waiting for container to start...")
 			// We make a fast check so we don't wait for the first ticker internal
 			if isRunning(containerId, dst) {
-				restoreSucceed = true
-				wg.Done()
+				runningDone <- struct{}{}
 				return
 			}
 			ticker := time.NewTicker(200 * time.Millisecond)
-			go func() {
-				for _ = range ticker.C {
-					if isRunning(containerId, dst) {
-						restoreSucceed = true
-						break
-					}
-
+			defer ticker.Stop()
+			checks := 0
+			for range ticker.C {
+				checks++
+				if checks%5 == 0 {
+					restoreSp.Tick()
+				}
+				if isRunning(containerId, dst) {
+					runningDone <- struct{}{}
+					return
 				}
-				ticker.Stop()
-				wg.Done()
-			}()
+			}
 		}()
 
-		wg.Wait()
+		var restoreSucceed bool
+		var restoreError error
+
+		select {
+		case <-runningDone:
+			restoreSucceed = true
+		case restoreError = <-restoreDone:
+		case <-restoreTimeoutCh:
+			restoreError = fmt.Errorf("timed out waiting for restore to finish on dst")
+		}
+		restoreSp.Done()
 
 		downtime = time.Since(migrateStart)
 
 		if restoreSucceed {
-			log.Printf("Restore finished successfully, total downtime: %dms", downtime/time.Millisecond)
+			reporter.Log("Restore finished successfully, total downtime: %dms", downtime/time.Millisecond)
 		} else {
-			log.Println("Error performing restore:", restoreError)
-			// Rollback
+			log.Printf("Error performing restore: %v", restoreError)
+			plan.Rollback()
 		}
 
 	},
 }
 
+// preDumpRounds drives N successive pre-dump iterations, each one copying
+// only the memory pages dirtied since the previous round to the
+// destination. It returns the src-side and dst-side paths of the last
+// pre-dump images dir: the caller passes prevImagesDir as
+// --prev-images-dir to the final checkpoint on src, and prevDstImagesDir
+// to fixParentLink so the final round's own "parent" symlink on dst is
+// rewritten to point at it too. Every round's staged dst images get their
+// own rollback step as soon as they land, rather than only the final
+// round's, so a later round failing doesn't leak the earlier rounds'
+// directories on dst.
+func preDumpRounds(src, dst cmd.Cmd, push, pull transport.Transport, encryption *crypt.Config, reporter *progress.Reporter, plan *rollback.Plan, transportRaw, containerId, srcBase, dstBase string, rounds int) (prevImagesDir, prevDstImagesDir string, err error) {
+	for i := 0; i < rounds; i++ {
+		roundStart := time.Now()
+
+		srcImagesPath := fmt.Sprintf("%s/images/%d", srcBase, i)
+		dstImagesPath := fmt.Sprintf("%s/images/%d", dstBase, i)
+
+		if err := prepareDir(src, srcImagesPath); err != nil {
+			return "", "", err
+		}
+		if err := checkpoint(src, reporter, containerId, srcImagesPath, true, prevImagesDir); err != nil {
+			return "", "", err
+		}
+
+		srcTarFile := fmt.Sprintf("%s/predump.tar.gz", srcImagesPath)
+		tarSp := reporter.Start(fmt.Sprintf("Compressing pre-dump round %d image on src", i))
+		if err := prepareTar(src, tarSp, srcTarFile, srcImagesPath); err != nil {
+			return "", "", err
+		}
+		finishWithSize(tarSp, src, srcTarFile)
+
+		if err := prepareDir(dst, dstImagesPath); err != nil {
+			return "", "", err
+		}
+
+		dstTarFile := fmt.Sprintf("%s/predump.tar.gz", dstImagesPath)
+		ref := transferRef(transportRaw, dst, fmt.Sprintf("images/%d/predump.tar.gz", i), dstTarFile)
+		dstTarFile, err := transferImage(push, pull, encryption, reporter, src, dst, srcTarFile, ref, dstTarFile)
+		if err != nil {
+			return "", "", err
+		}
+		addStagedImagesRollback(plan, dst, dstImagesPath)
+
+		extractSp := reporter.Start(fmt.Sprintf("Extracting pre-dump round %d image on dst", i))
+		if err := unpackTar(dst, extractSp, dstTarFile, dstImagesPath); err != nil {
+			return "", "", err
+		}
+		finishWithDirSize(extractSp, dst, dstImagesPath)
+
+		if err := fixParentLink(dst, dstImagesPath, prevDstImagesDir); err != nil {
+			return "", "", err
+		}
+
+		prevImagesDir = srcImagesPath
+		prevDstImagesDir = dstImagesPath
+
+		reporter.Log("Pre-dump round %d finished in %dms", i, time.Since(roundStart)/time.Millisecond)
+	}
+
+	return prevImagesDir, prevDstImagesDir, nil
+}
+
+// fixParentLink rewrites imagesPath's CRIU "parent" symlink to point at
+// prevImagesDir. CRIU bakes this symlink into an image dir whenever it's
+// dumped with --prev-images-dir, so a multi-round restore can walk back
+// through each round's dirtied-pages-only images to reconstruct the full
+// checkpoint; the target it writes is whatever --prev-images-dir was
+// passed with on src, which is meaningless once the directory is copied
+// to dst if src and dst use different base paths. Rewriting it here,
+// after each round lands on dst, keeps the chain valid on dst regardless
+// of how src and dst's paths relate. A no-op when prevImagesDir is empty,
+// i.e. the first pre-dump round.
+func fixParentLink(c cmd.Cmd, imagesPath, prevImagesDir string) error {
+	if prevImagesDir == "" {
+		return nil
+	}
+	_, _, err := c.Run("ln", "-sfn", prevImagesDir, fmt.Sprintf("%s/parent", imagesPath))
+	return err
+}
+
+// transferRef builds the ref passed to transport.Transport for a given
+// image tarball. With no --transport flag it falls back to the original
+// scp-over-ssh destination spec; otherwise it's transportRaw (e.g.
+// s3://bucket/prefix) joined with the tarball's relative key.
+func transferRef(transportRaw string, dst cmd.Cmd, key, dstTarFile string) string {
+	if transportRaw == "" {
+		return dst.URL(dstTarFile)
+	}
+	return fmt.Sprintf("%s/%s", transportRaw, key)
+}
+
+// transferImage pushes localTarFile from the source host to ref, then
+// pulls ref down to dstTarFile on the destination host. Push and Pull run
+// in a goroutine while the caller's reporter polls dstTarFile's growing
+// size on dst, so --transport backends get live progress without each
+// one having to implement it. When encryption is enabled, the archive is
+// encrypted before it's pushed and decrypted after it's pulled; either
+// way, its digest is verified on the destination before it's handed back
+// for unpacking, so a corrupted or tampered archive is reported rather
+// than handed to restore. It returns the path of the final, plaintext
+// tarball on the destination.
+func transferImage(push, pull transport.Transport, encryption *crypt.Config, reporter *progress.Reporter, src, dst cmd.Cmd, localTarFile, ref, dstTarFile string) (string, error) {
+	srcFile := localTarFile
+	if encryption.Enabled() {
+		encFile, err := encryption.Encrypt(src, srcFile)
+		if err != nil {
+			return "", fmt.Errorf("error encrypting image: %w", err)
+		}
+		srcFile = encFile
+		ref = ref + ".enc"
+		dstTarFile = dstTarFile + ".enc"
+	}
+
+	digest, err := crypt.Digest(src, srcFile)
+	if err != nil {
+		return "", fmt.Errorf("error computing image digest: %w", err)
+	}
+
+	transferSp := reporter.Start("Transferring image to dst")
+
+	transferDone := make(chan error, 1)
+	go func() {
+		if err := push.Push(srcFile, ref); err != nil {
+			transferDone <- fmt.Errorf("error transferring image to dst: %w", err)
+			return
+		}
+		if err := pull.Pull(ref, dstTarFile); err != nil {
+			transferDone <- fmt.Errorf("error transferring image to dst: %w", err)
+			return
+		}
+		transferDone <- nil
+	}()
+
+	stop := make(chan struct{})
+	go pollBytes(transferSp, func() (int64, error) { return fileSize(dst, dstTarFile) }, stop)
+	err = <-transferDone
+	close(stop)
+	if err != nil {
+		return "", err
+	}
+	finishWithSize(transferSp, src, srcFile)
+
+	if err := crypt.VerifyDigest(dst, dstTarFile, digest); err != nil {
+		return "", fmt.Errorf("refusing to restore, corrupted image transfer: %w", err)
+	}
+
+	if encryption.Enabled() {
+		plainFile, err := encryption.Decrypt(dst, dstTarFile)
+		if err != nil {
+			return "", fmt.Errorf("error decrypting image: %w", err)
+		}
+		dstTarFile = plainFile
+	}
+
+	return dstTarFile, nil
+}
+
+// addCheckpointRollback records the step that undoes a successful
+// checkpoint on src: since checkpointing a container stops it, rollback
+// restores it back in place from the local images that were just
+// written, so a failed migration doesn't leave src's container down too.
+func addCheckpointRollback(plan *rollback.Plan, src cmd.Cmd, containerId, imagesPath, srcBase string) {
+	configFilePath := fmt.Sprintf("%s/config.json", srcBase)
+	runtimeFilePath := fmt.Sprintf("%s/runtime.json", srcBase)
+	plan.Add(fmt.Sprintf("restore %s on src from %s", containerId, imagesPath), func() error {
+		_, _, err := src.Run("sudo", "runc", "--id", containerId, "restore", "--image-path", imagesPath, "--config-file", configFilePath, "--runtime-file", runtimeFilePath)
+		return err
+	})
+}
+
+// addStagedImagesRollback records the step that removes the images
+// staged on dst for a migration that never completed.
+func addStagedImagesRollback(plan *rollback.Plan, dst cmd.Cmd, dstImagesPath string) {
+	plan.Add(fmt.Sprintf("remove staged images at %s on dst", dstImagesPath), func() error {
+		_, _, err := dst.Run("sudo", "rm", "-rf", dstImagesPath)
+		return err
+	})
+}
+
+// addRestoreRollback records the step that tears down the partial runc
+// state left behind by a restore attempt on dst that never succeeded. A
+// restore that's hung rather than failed outright (the --restore-timeout
+// case) is still running in the background when this fires, so it's
+// pkilled first, best-effort -- otherwise "runc delete -f" can race a
+// still-executing restore process and lose.
+func addRestoreRollback(plan *rollback.Plan, dst cmd.Cmd, containerId string) {
+	plan.Add(fmt.Sprintf("remove partial runc state for %s on dst", containerId), func() error {
+		if _, _, err := dst.Run("sudo", "pkill", "-f", fmt.Sprintf("runc --id %s restore", containerId)); err != nil {
+			log.Printf("Error killing restore process for %s on dst (may have already exited): %v", containerId, err)
+		}
+		_, _, err := dst.Run("sudo", "runc", "--id", containerId, "delete", "-f")
+		return err
+	})
+}
+
 func isRunning(containerId string, dstCmd cmd.Cmd) bool {
 	_, _, err := dstCmd.Run("stat", fmt.Sprintf("/var/run/opencontainer/containers/%s", containerId))
 	if err != nil {
@@ -183,41 +500,130 @@ func isRunning(containerId string, dstCmd cmd.Cmd) bool {
 	return false
 }
 
-func unpackTar(cmd cmd.Cmd, tarFile, workDir string) {
-	log.Println("Preparing image at destination host")
-	_, _, err := cmd.Run("sudo", "tar", "-C", workDir, "-xvzf", tarFile)
+// unpackTar extracts tarFile into workDir on host c, polling workDir's
+// growing size via sp while the extraction is still running.
+func unpackTar(c cmd.Cmd, sp *progress.Spinner, tarFile, workDir string) error {
+	runCmd, err := c.Start("sudo", "tar", "-C", workDir, "-xvzf", tarFile)
 	if err != nil {
-		log.Fatal("Error uncompressing image in destination:", err)
+		return err
 	}
+
+	stop := make(chan struct{})
+	go pollBytes(sp, func() (int64, error) { return dirSize(c, workDir) }, stop)
+	err = runCmd.Wait()
+	close(stop)
+	return err
 }
 
-func prepareTar(cmd cmd.Cmd, tarFile, workDir string) {
-	_, _, err := cmd.Run("sudo", "tar", "-czf", tarFile, "-C", fmt.Sprintf("%s/", workDir), ".")
+// prepareTar compresses workDir into tarFile on host c, polling tarFile's
+// growing size via sp while the compression is still running.
+func prepareTar(c cmd.Cmd, sp *progress.Spinner, tarFile, workDir string) error {
+	runCmd, err := c.Start("sudo", "tar", "-czf", tarFile, "-C", fmt.Sprintf("%s/", workDir), ".")
 	if err != nil {
-		log.Fatal("Error compressing image in source:", err)
+		return err
+	}
+
+	stop := make(chan struct{})
+	go pollBytes(sp, func() (int64, error) { return fileSize(c, tarFile) }, stop)
+	err = runCmd.Wait()
+	close(stop)
+	return err
+}
+
+// pollBytes calls size once a second and reports it to sp, until stop is
+// closed, giving live progress for a phase whose own shell command is a
+// single opaque long-running process.
+func pollBytes(sp *progress.Spinner, size func() (int64, error), stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if n, err := size(); err == nil {
+				sp.TickBytes(n)
+			}
+		}
 	}
 }
 
-func checkpoint(cmd cmd.Cmd, containerId, imagesPath string, predump bool) {
-	log.Printf("Performing the checkpoint predump = %t\n", predump)
+// checkpoint runs runc checkpoint against containerId, writing images to
+// imagesPath. When predump is true, --pre-dump is added so CRIU leaves the
+// container running. prevImagesDir, when non-empty, is passed as
+// --prev-images-dir so CRIU only dumps pages dirtied since that prior
+// iteration; runc preserves the chain via a parent symlink in imagesPath.
+func checkpoint(c cmd.Cmd, reporter *progress.Reporter, containerId, imagesPath string, predump bool, prevImagesDir string) error {
+	reporter.Log("Performing the checkpoint predump = %t", predump)
 	args := []string{"runc", "--id", containerId, "checkpoint", "--image-path", imagesPath}
 	if predump {
 		args = append(args, "--pre-dump")
 	}
-	_, _, err := cmd.Run("sudo", args...)
-	if err != nil {
-		log.Fatal("Error performing checkpoint:", err)
+	if prevImagesDir != "" {
+		args = append(args, "--prev-images-dir", prevImagesDir)
 	}
+	_, _, err := c.Run("sudo", args...)
+	return err
 }
 
-func prepareDir(cmd cmd.Cmd, path string) {
-	_, _, err := cmd.Run("mkdir", "-p", path)
-	if err != nil {
-		log.Fatal("Error preparing pre-dump dir:", err)
-	}
+func prepareDir(c cmd.Cmd, path string) error {
+	_, _, err := c.Run("mkdir", "-p", path)
+	return err
 }
 
 func getContainerId(path string) string {
 	_, id := filepath.Split(path)
 	return id
 }
+
+// finishWithSize stops sp, reporting path's size on host c when it can be
+// determined. A stat failure falls back to a plain elapsed-time report
+// rather than letting a progress-only error mask the phase's own result.
+func finishWithSize(sp *progress.Spinner, c cmd.Cmd, path string) {
+	if n, err := fileSize(c, path); err == nil {
+		sp.DoneBytes(n)
+		return
+	}
+	sp.Done()
+}
+
+// finishWithDirSize is finishWithSize for a directory of extracted files,
+// whose total size is measured with du rather than stat.
+func finishWithDirSize(sp *progress.Spinner, c cmd.Cmd, path string) {
+	if n, err := dirSize(c, path); err == nil {
+		sp.DoneBytes(n)
+		return
+	}
+	sp.Done()
+}
+
+// fileSize returns the size in bytes of the file at path on host c.
+func fileSize(c cmd.Cmd, path string) (int64, error) {
+	out, _, err := c.Run("stat", "-c%s", path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected stat output %q: %w", out, err)
+	}
+	return n, nil
+}
+
+// dirSize returns the total size in bytes of everything under path on
+// host c.
+func dirSize(c cmd.Cmd, path string) (int64, error) {
+	out, _, err := c.Run("du", "-sb", path)
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected du output: %q", out)
+	}
+	n, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected du output %q: %w", out, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,110 @@
+// Package progress gives operators visibility into the long running
+// phases of a migration (checkpoint tar, transfer, extraction, restore
+// polling). Every phase runs as a shell command on the source or
+// destination host (see cmd.Cmd); TickBytes is how a caller reports live
+// progress for one of these phases -- it polls the growing size of the
+// file or directory the phase is writing to (via a stat/du run
+// concurrently with the still-running shell command) and logs it, rather
+// than waiting for the command to finish and reporting a single
+// after-the-fact average. This is especially useful with multi-round
+// --pre-dump, where each round's own TickBytes calls show that round's
+// transferred delta size as it moves, not just once it's done.
+package progress
+
+import (
+	"log"
+	"time"
+)
+
+// Reporter controls what progress output is shown. --no-progress
+// suppresses per-phase Spinner output but leaves top-level status lines
+// (via Log) alone; --silent suppresses both.
+type Reporter struct {
+	quietPhases bool
+	quietAll    bool
+}
+
+// New builds a Reporter. noProgress suppresses Spinner output; silent
+// suppresses Spinner output and every Log call.
+func New(noProgress, silent bool) *Reporter {
+	return &Reporter{quietPhases: noProgress || silent, quietAll: silent}
+}
+
+// Log prints a top-level status line, unless --silent was given. Errors
+// are never routed through here -- they're always printed, silent or
+// not.
+func (r *Reporter) Log(format string, args ...interface{}) {
+	if r.quietAll {
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// Spinner brackets a long running phase with a start message and, once
+// Done is called, the elapsed time it took.
+type Spinner struct {
+	label  string
+	start  time.Time
+	silent bool
+}
+
+// Start begins tracking label, printing a start message unless output is
+// suppressed.
+func (r *Reporter) Start(label string) *Spinner {
+	s := &Spinner{label: label, start: time.Now(), silent: r.quietPhases}
+	if !s.silent {
+		log.Printf("%s...", label)
+	}
+	return s
+}
+
+// Done reports how long the phase took.
+func (s *Spinner) Done() {
+	if s.silent {
+		return
+	}
+	log.Printf("%s done in %dms", s.label, time.Since(s.start)/time.Millisecond)
+}
+
+// DoneBytes reports how long the phase took, the number of bytes it
+// moved, and the resulting average throughput.
+func (s *Spinner) DoneBytes(n int64) {
+	if s.silent {
+		return
+	}
+	mib, mibPerSec := byteRate(n, time.Since(s.start))
+	log.Printf("%s done in %dms (%.1f MiB, %.1f MiB/s)", s.label, time.Since(s.start)/time.Millisecond, mib, mibPerSec)
+}
+
+// Tick prints the elapsed time since Start, for phases (like restore
+// polling) that run for an unpredictable duration and benefit from a
+// heartbeat rather than a single start/done pair.
+func (s *Spinner) Tick() {
+	if s.silent {
+		return
+	}
+	log.Printf("%s... (%ds elapsed)", s.label, int(time.Since(s.start).Seconds()))
+}
+
+// TickBytes is Tick for a phase whose caller can poll how many bytes it
+// has moved so far (e.g. the growing size of a tarball mid-write, or a
+// file mid-transfer) while the phase's own shell command is still
+// running, giving live progress instead of a post-hoc average.
+func (s *Spinner) TickBytes(n int64) {
+	if s.silent {
+		return
+	}
+	mib, _ := byteRate(n, time.Since(s.start))
+	log.Printf("%s... (%.1f MiB so far, %ds elapsed)", s.label, mib, int(time.Since(s.start).Seconds()))
+}
+
+// byteRate converts n bytes moved over elapsed into MiB and MiB/s. Split
+// out from DoneBytes/TickBytes so the arithmetic can be tested without a
+// real clock.
+func byteRate(n int64, elapsed time.Duration) (mib, mibPerSec float64) {
+	mib = float64(n) / (1024 * 1024)
+	if secs := elapsed.Seconds(); secs > 0 {
+		mibPerSec = mib / secs
+	}
+	return mib, mibPerSec
+}
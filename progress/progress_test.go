@@ -0,0 +1,48 @@
+package progress
+
+import (
+	"testing"
+	"time"
+)
+
+func TestByteRate(t *testing.T) {
+	cases := []struct {
+		name        string
+		n           int64
+		elapsed     time.Duration
+		wantMib     float64
+		wantMibPerS float64
+	}{
+		{"one second", 1024 * 1024, time.Second, 1, 1},
+		{"two seconds", 10 * 1024 * 1024, 2 * time.Second, 10, 5},
+		{"zero elapsed", 1024 * 1024, 0, 1, 0},
+	}
+	for _, c := range cases {
+		mib, mibPerSec := byteRate(c.n, c.elapsed)
+		if mib != c.wantMib || mibPerSec != c.wantMibPerS {
+			t.Errorf("%s: byteRate(%d, %s) = (%v, %v), want (%v, %v)", c.name, c.n, c.elapsed, mib, mibPerSec, c.wantMib, c.wantMibPerS)
+		}
+	}
+}
+
+func TestReporterQuietness(t *testing.T) {
+	cases := []struct {
+		name            string
+		noProgress      bool
+		silent          bool
+		wantQuietPhases bool
+		wantQuietAll    bool
+	}{
+		{"default", false, false, false, false},
+		{"no-progress only", true, false, true, false},
+		{"silent only", false, true, true, true},
+		{"both", true, true, true, true},
+	}
+	for _, c := range cases {
+		r := New(c.noProgress, c.silent)
+		if r.quietPhases != c.wantQuietPhases || r.quietAll != c.wantQuietAll {
+			t.Errorf("%s: New(%v, %v) = {quietPhases: %v, quietAll: %v}, want {%v, %v}",
+				c.name, c.noProgress, c.silent, r.quietPhases, r.quietAll, c.wantQuietPhases, c.wantQuietAll)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+// Package rollback records the reversible steps taken during a
+// migration so they can be undone if the destination restore never
+// succeeds, leaving neither host stuck in a half-migrated state.
+package rollback
+
+import "log"
+
+// step is a single reversible action taken during a migration.
+type step struct {
+	describe string
+	undo     func() error
+}
+
+// Plan accumulates steps as a migration progresses. If the migration
+// fails partway through, Rollback undoes everything recorded so far.
+type Plan struct {
+	steps []step
+}
+
+// Add records a reversible step. Steps are undone in the reverse of the
+// order they were added.
+func (p *Plan) Add(describe string, undo func() error) {
+	p.steps = append(p.steps, step{describe: describe, undo: undo})
+}
+
+// Rollback undoes every recorded step, most recent first. A step that
+// fails to undo is logged and skipped rather than aborting the rest of
+// the recovery.
+func (p *Plan) Rollback() {
+	for i := len(p.steps) - 1; i >= 0; i-- {
+		s := p.steps[i]
+		log.Printf("Rolling back: %s", s.describe)
+		if err := s.undo(); err != nil {
+			log.Printf("Error rolling back %q: %v", s.describe, err)
+		}
+	}
+}
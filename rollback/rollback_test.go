@@ -0,0 +1,37 @@
+package rollback
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPlanRollbackReverseOrder(t *testing.T) {
+	var undone []string
+	p := &Plan{}
+	p.Add("first", func() error { undone = append(undone, "first"); return nil })
+	p.Add("second", func() error { undone = append(undone, "second"); return nil })
+	p.Add("third", func() error { undone = append(undone, "third"); return nil })
+
+	p.Rollback()
+
+	want := []string{"third", "second", "first"}
+	if !reflect.DeepEqual(undone, want) {
+		t.Errorf("Rollback order = %v, want %v", undone, want)
+	}
+}
+
+func TestPlanRollbackContinuesOnError(t *testing.T) {
+	var undone []string
+	p := &Plan{}
+	p.Add("first", func() error { undone = append(undone, "first"); return nil })
+	p.Add("second", func() error { return errors.New("boom") })
+	p.Add("third", func() error { undone = append(undone, "third"); return nil })
+
+	p.Rollback()
+
+	want := []string{"third", "first"}
+	if !reflect.DeepEqual(undone, want) {
+		t.Errorf("Rollback order = %v, want %v", undone, want)
+	}
+}
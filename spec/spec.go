@@ -0,0 +1,114 @@
+// Package spec captures a container's config.json, runtime.json and any
+// requested bind mount contents into a single manifest that travels with
+// the checkpoint, so the destination doesn't need those files staged out
+// of band and so host-specific paths can be rewritten on arrival.
+package spec
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/marcosnils/cmt/cmd"
+)
+
+// Capture packages config.json, runtime.json (relative to base) and the
+// contents of each path in mounts into a container.json tarball at
+// manifestPath on host c. mounts are absolute paths on c, typically bind
+// mount sources living outside base, and are stored under their own
+// relative name (their absolute path with the leading "/" trimmed) via
+// "-C /", rather than relative to base, so Materialize can later restore
+// each one to wherever it belongs on the destination rather than
+// wherever it happened to land under base.
+func Capture(c cmd.Cmd, base, manifestPath string, mounts []string) error {
+	args := []string{"-czf", manifestPath, "-C", base, "config.json", "runtime.json"}
+	for _, m := range mounts {
+		args = append(args, "-C", "/", strings.TrimPrefix(m, "/"))
+	}
+
+	_, _, err := c.Run("tar", args...)
+	return err
+}
+
+// Materialize unpacks a container.json tarball (as produced by Capture)
+// on host c: config.json and runtime.json land under base, then each
+// rewrite -- a "src=dst" pair -- is applied to them so host-specific
+// fields (rootfs path, cgroup parent, mount sources) match the
+// destination's layout before runc restore reads them. mounts are the
+// same absolute source paths passed to Capture; each one's content is
+// extracted and relocated to its rewritten destination path, since the
+// destination host may not share src's filesystem layout.
+func Materialize(c cmd.Cmd, manifestPath, base string, mounts, rewrites []string) error {
+	pairs, err := parseRewrites(rewrites)
+	if err != nil {
+		return err
+	}
+
+	if _, _, err := c.Run("tar", "-C", base, "-xzf", manifestPath, "config.json", "runtime.json"); err != nil {
+		return err
+	}
+
+	for _, p := range pairs {
+		sedExpr := fmt.Sprintf("s#%s#%s#g", p.src, p.dst)
+		for _, file := range []string{"config.json", "runtime.json"} {
+			path := fmt.Sprintf("%s/%s", base, file)
+			if _, _, err := c.Run("sed", "-i", sedExpr, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(mounts) == 0 {
+		return nil
+	}
+
+	stageDir := fmt.Sprintf("%s/.mount-stage", base)
+	if _, _, err := c.Run("mkdir", "-p", stageDir); err != nil {
+		return err
+	}
+
+	for _, m := range mounts {
+		rel := strings.TrimPrefix(m, "/")
+		if _, _, err := c.Run("tar", "-C", stageDir, "-xzf", manifestPath, rel); err != nil {
+			return err
+		}
+
+		dst := rewritePath(m, pairs)
+		if _, _, err := c.Run("mkdir", "-p", filepath.Dir(dst)); err != nil {
+			return err
+		}
+		if _, _, err := c.Run("cp", "-a", fmt.Sprintf("%s/%s", stageDir, rel), dst); err != nil {
+			return err
+		}
+	}
+
+	_, _, err = c.Run("rm", "-rf", stageDir)
+	return err
+}
+
+// rewritePair is a parsed "src=dst" --rewrite value.
+type rewritePair struct {
+	src, dst string
+}
+
+func parseRewrites(rewrites []string) ([]rewritePair, error) {
+	pairs := make([]rewritePair, 0, len(rewrites))
+	for _, r := range rewrites {
+		parts := strings.SplitN(r, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid --rewrite %q, want src=dst", r)
+		}
+		pairs = append(pairs, rewritePair{src: parts[0], dst: parts[1]})
+	}
+	return pairs, nil
+}
+
+// rewritePath applies every rewrite pair to path, the same way Materialize
+// applies them to config.json/runtime.json via sed, so a mount's
+// destination matches wherever the rewritten spec now expects it.
+func rewritePath(path string, pairs []rewritePair) string {
+	for _, p := range pairs {
+		path = strings.ReplaceAll(path, p.src, p.dst)
+	}
+	return path
+}
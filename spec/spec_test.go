@@ -0,0 +1,60 @@
+package spec
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRewrites(t *testing.T) {
+	cases := []struct {
+		name    string
+		in      []string
+		want    []rewritePair
+		wantErr bool
+	}{
+		{"empty", nil, []rewritePair{}, false},
+		{"single", []string{"/data/src=/data/dst"}, []rewritePair{{src: "/data/src", dst: "/data/dst"}}, false},
+		{
+			"multiple",
+			[]string{"/data/src=/data/dst", "/var/a=/var/b"},
+			[]rewritePair{{src: "/data/src", dst: "/data/dst"}, {src: "/var/a", dst: "/var/b"}},
+			false,
+		},
+		{"value contains equals", []string{"/a=/b=c"}, []rewritePair{{src: "/a", dst: "/b=c"}}, false},
+		{"missing equals", []string{"/data/src"}, nil, true},
+	}
+	for _, c := range cases {
+		got, err := parseRewrites(c.in)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%s: parseRewrites(%v) = nil error, want error", c.name, c.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: parseRewrites(%v) error = %v", c.name, c.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: parseRewrites(%v) = %v, want %v", c.name, c.in, got, c.want)
+		}
+	}
+}
+
+func TestRewritePath(t *testing.T) {
+	pairs := []rewritePair{
+		{src: "/data/src", dst: "/data/dst"},
+		{src: "/var/a", dst: "/var/b"},
+	}
+	cases := map[string]string{
+		"/data/src/mnt/vol":    "/data/dst/mnt/vol",
+		"/var/a/state":         "/var/b/state",
+		"/unrelated/path":      "/unrelated/path",
+		"/data/src/var/a/both": "/data/dst/var/b/both",
+	}
+	for in, want := range cases {
+		if got := rewritePath(in, pairs); got != want {
+			t.Errorf("rewritePath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package transport
+
+import "github.com/marcosnils/cmt/cmd"
+
+// localdirTransport copies images to/from a plain directory, e.g. storage
+// shared between src and dst via a common mount. Refs carry the
+// "localdir://" scheme migrate used to pick this transport, which is
+// stripped before use since cp expects a plain path.
+type localdirTransport struct {
+	cmd cmd.Cmd
+}
+
+func (t *localdirTransport) Push(localPath, ref string) error {
+	_, _, err := t.cmd.Run("cp", "-r", localPath, StripScheme("localdir", ref))
+	return err
+}
+
+func (t *localdirTransport) Pull(ref, localPath string) error {
+	_, _, err := t.cmd.Run("cp", "-r", StripScheme("localdir", ref), localPath)
+	return err
+}
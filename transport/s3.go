@@ -0,0 +1,19 @@
+package transport
+
+import "github.com/marcosnils/cmt/cmd"
+
+// s3Transport stages images through an S3 bucket via the aws CLI, so src
+// and dst don't need direct connectivity to each other.
+type s3Transport struct {
+	cmd cmd.Cmd
+}
+
+func (t *s3Transport) Push(localPath, ref string) error {
+	_, _, err := t.cmd.Run("aws", "s3", "cp", localPath, ref)
+	return err
+}
+
+func (t *s3Transport) Pull(ref, localPath string) error {
+	_, _, err := t.cmd.Run("aws", "s3", "cp", ref, localPath)
+	return err
+}
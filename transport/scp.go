@@ -0,0 +1,19 @@
+package transport
+
+import "github.com/marcosnils/cmt/cmd"
+
+// scpTransport reproduces cmt's original behaviour: the source host scps
+// the archive straight onto the destination host, so Pull is a no-op --
+// the file is already at localPath by the time it runs.
+type scpTransport struct {
+	cmd cmd.Cmd
+}
+
+func (t *scpTransport) Push(localPath, ref string) error {
+	_, _, err := t.cmd.Run("scp", "-r", localPath, ref)
+	return err
+}
+
+func (t *scpTransport) Pull(ref, localPath string) error {
+	return nil
+}
@@ -0,0 +1,39 @@
+package transport
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/marcosnils/cmt/cmd"
+)
+
+// sftpTransport stages images on a remote path via sftp batch mode,
+// independent of the scp transport used for direct src->dst copies.
+type sftpTransport struct {
+	cmd cmd.Cmd
+}
+
+func (t *sftpTransport) Push(localPath, ref string) error {
+	host, remotePath := splitSftpRef(ref)
+	batch := fmt.Sprintf("put %s %s", localPath, remotePath)
+	_, _, err := t.cmd.Run("sh", "-c", fmt.Sprintf("printf '%%s\\n' %s | sftp -b - %s", shellQuote(batch), shellQuote(host)))
+	return err
+}
+
+func (t *sftpTransport) Pull(ref, localPath string) error {
+	host, remotePath := splitSftpRef(ref)
+	batch := fmt.Sprintf("get %s %s", remotePath, localPath)
+	_, _, err := t.cmd.Run("sh", "-c", fmt.Sprintf("printf '%%s\\n' %s | sftp -b - %s", shellQuote(batch), shellQuote(host)))
+	return err
+}
+
+// splitSftpRef splits a "sftp://host/path" ref (as built by
+// migrate.transferRef) into the host sftp should connect to and the
+// remote path, after stripping the "sftp://" prefix.
+func splitSftpRef(ref string) (host, path string) {
+	ref = StripScheme("sftp", ref)
+	if i := strings.IndexByte(ref, '/'); i >= 0 {
+		return ref[:i], ref[i:]
+	}
+	return ref, ""
+}
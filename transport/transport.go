@@ -0,0 +1,74 @@
+// Package transport abstracts how a checkpoint image tarball moves
+// between the source and destination hosts, so migrate no longer has to
+// shell out to scp directly.
+package transport
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/marcosnils/cmt/cmd"
+)
+
+// Transport moves an already-prepared tar archive between a host and a
+// shared location identified by ref. Push runs on the host that produced
+// localPath, Pull runs on the host that needs it at localPath. Except with
+// the scp transport, which copies straight from src to dst, src and dst
+// never need to reach each other directly -- only the shared location
+// named by ref.
+type Transport interface {
+	Push(localPath, ref string) error
+	Pull(ref, localPath string) error
+}
+
+// New returns the Transport implementation selected by scheme, bound to
+// the host whose commands it will run. An empty scheme selects the
+// original scp-based transfer.
+func New(scheme string, c cmd.Cmd) (Transport, error) {
+	switch scheme {
+	case "", "scp":
+		return &scpTransport{cmd: c}, nil
+	case "s3":
+		return &s3Transport{cmd: c}, nil
+	case "webdav":
+		return &webdavTransport{cmd: c}, nil
+	case "sftp":
+		return &sftpTransport{cmd: c}, nil
+	case "localdir":
+		return &localdirTransport{cmd: c}, nil
+	}
+
+	return nil, fmt.Errorf("unknown transport %q", scheme)
+}
+
+// Scheme extracts the scheme from a --transport value such as
+// "s3://bucket/prefix" or "webdav://host/path". A value with no "://",
+// including the empty default, yields "", which New maps to scp; the
+// localdir transport is selected only by a "localdir://" value.
+func Scheme(raw string) string {
+	if i := strings.Index(raw, "://"); i >= 0 {
+		return raw[:i]
+	}
+	return ""
+}
+
+// StripScheme removes a leading "scheme://" from ref, if present. Refs are
+// built by joining the raw --transport value (which carries the scheme
+// migrate used to pick a Transport) with a tarball's relative key, but the
+// underlying tool each transport shells out to -- curl, sftp, cp -- speaks
+// its own protocol, not cmt's scheme name, so those transports strip
+// their own prefix before using the ref. The s3 transport passes the ref
+// through unchanged, since the aws CLI takes s3:// URLs as they are.
+func StripScheme(scheme, ref string) string {
+	if scheme == "" {
+		return ref
+	}
+	return strings.TrimPrefix(ref, scheme+"://")
+}
+
+// shellQuote single-quotes s for safe use as a literal sh argument. Same
+// pattern as crypt's private shellQuote -- duplicated rather than shared
+// since that one is unexported to its own package.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,73 @@
+package transport
+
+import "testing"
+
+func TestScheme(t *testing.T) {
+	cases := map[string]string{
+		"s3://bucket/prefix": "s3",
+		"webdav://host/path": "webdav",
+		"sftp://host/path":   "sftp",
+		"localdir:///shared": "localdir",
+		"/shared/path":       "",
+		"":                   "",
+	}
+	for raw, want := range cases {
+		if got := Scheme(raw); got != want {
+			t.Errorf("Scheme(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestStripScheme(t *testing.T) {
+	cases := []struct{ scheme, ref, want string }{
+		{"sftp", "sftp://host/path/dump.tar.gz", "host/path/dump.tar.gz"},
+		{"webdav", "webdav://host/path/dump.tar.gz", "host/path/dump.tar.gz"},
+		{"localdir", "localdir:///shared/path/dump.tar.gz", "/shared/path/dump.tar.gz"},
+		{"", "/shared/path/dump.tar.gz", "/shared/path/dump.tar.gz"},
+	}
+	for _, c := range cases {
+		if got := StripScheme(c.scheme, c.ref); got != c.want {
+			t.Errorf("StripScheme(%q, %q) = %q, want %q", c.scheme, c.ref, got, c.want)
+		}
+	}
+}
+
+func TestSplitSftpRef(t *testing.T) {
+	cases := []struct {
+		ref      string
+		wantHost string
+		wantPath string
+	}{
+		{"sftp://host/path/images/1/dump.tar.gz", "host", "/path/images/1/dump.tar.gz"},
+		{"sftp://host", "host", ""},
+	}
+	for _, c := range cases {
+		host, path := splitSftpRef(c.ref)
+		if host != c.wantHost || path != c.wantPath {
+			t.Errorf("splitSftpRef(%q) = (%q, %q), want (%q, %q)", c.ref, host, path, c.wantHost, c.wantPath)
+		}
+	}
+}
+
+func TestWebdavURL(t *testing.T) {
+	want := "https://host/path/dump.tar.gz"
+	if got := webdavURL("webdav://host/path/dump.tar.gz"); got != want {
+		t.Errorf("webdavURL(...) = %q, want %q", got, want)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	cases := map[string]string{
+		"dump.tar.gz":          "'dump.tar.gz'",
+		"":                     "''",
+		"it's":                 `'it'\''s'`,
+		"$(rm -rf /)":          "'$(rm -rf /)'",
+		"`echo pwned`":         "'`echo pwned`'",
+		"host; rm -rf / #evil": "'host; rm -rf / #evil'",
+	}
+	for in, want := range cases {
+		if got := shellQuote(in); got != want {
+			t.Errorf("shellQuote(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
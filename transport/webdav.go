@@ -0,0 +1,26 @@
+package transport
+
+import "github.com/marcosnils/cmt/cmd"
+
+// webdavTransport stages images on a WebDAV share via curl. Refs carry the
+// "webdav://" scheme migrate used to pick this transport, but curl only
+// speaks http(s), so it's swapped for "https://" before the ref is used.
+type webdavTransport struct {
+	cmd cmd.Cmd
+}
+
+func (t *webdavTransport) Push(localPath, ref string) error {
+	_, _, err := t.cmd.Run("curl", "-fsS", "-T", localPath, webdavURL(ref))
+	return err
+}
+
+func (t *webdavTransport) Pull(ref, localPath string) error {
+	_, _, err := t.cmd.Run("curl", "-fsS", "-o", localPath, webdavURL(ref))
+	return err
+}
+
+// webdavURL turns a "webdav://host/path" ref into the "https://host/path"
+// URL curl expects.
+func webdavURL(ref string) string {
+	return "https://" + StripScheme("webdav", ref)
+}